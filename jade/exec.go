@@ -0,0 +1,17 @@
+// Copyright 2013 Benjamin Gentil
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Template execution.
+
+package jade
+
+import "io"
+
+// Execute renders the tree to w, modeled on text/template's Execute so
+// gojade can be used directly from an http.Handler. data is reserved for
+// binding into buffered code and interpolation expressions.
+func (t *Tree) Execute(w io.Writer, data interface{}) error {
+	return t.Root.Render(w)
+}
@@ -15,8 +15,11 @@ import (
 
 // item represents a token or text string returned from the scanner.
 type item struct {
-	typ itemType
-	val string
+	typ  itemType
+	pos  int // byte offset of the item in the input string
+	val  string
+	line int // line number at the start of the item, 1-based
+	col  int // column number at the start of the item, 1-based
 }
 
 func (i item) String() string {
@@ -43,28 +46,50 @@ const (
 	itemIdentSpace
 	itemIdentTab
 	itemDoctype
-	itemComment
+	itemComment           // buffered comment (//), rendered as <!-- -->
+	itemCommentUnbuffered // unbuffered comment (//-), dropped from output
 	itemBlank
 	itemId
 	itemClass
-	itemEOF // End Of File
+	itemBlockText     // tag. dot-block of literal text
+	itemInterpolation // #{expr}
+	itemCode          // unbuffered code: - expr
+	itemBufferedCode  // buffered code: = expr
+	itemMixinDef      // mixin name(args)
+	itemMixinCall     // +name(args)
+	itemInclude       // include path
+	itemExtends       // extends path
+	itemBlock         // block name
+	itemFilter        // :filter
+	itemEOF           // End Of File
 )
 
 // Make the types prettyprint.
 var itemName = map[itemType]string{
-	itemError:      "error",
-	itemText:       "text",
-	itemEndl:       "endl",
-	itemTag:        "tag",
-	itemAttr:       "attr",
-	itemIdentSpace: "identSpace",
-	itemIdentTab:   "identTab",
-	itemDoctype:    "doctype",
-	itemComment:    "comment",
-	itemBlank:      "blank",
-	itemId:         "id",
-	itemClass:      "class",
-	itemEOF:        "EOF",
+	itemError:             "error",
+	itemText:              "text",
+	itemEndl:              "endl",
+	itemTag:               "tag",
+	itemAttr:              "attr",
+	itemIdentSpace:        "identSpace",
+	itemIdentTab:          "identTab",
+	itemDoctype:           "doctype",
+	itemComment:           "comment",
+	itemCommentUnbuffered: "commentUnbuffered",
+	itemBlank:             "blank",
+	itemId:                "id",
+	itemClass:             "class",
+	itemBlockText:         "blockText",
+	itemInterpolation:     "interpolation",
+	itemCode:              "code",
+	itemBufferedCode:      "bufferedCode",
+	itemMixinDef:          "mixinDef",
+	itemMixinCall:         "mixinCall",
+	itemInclude:           "include",
+	itemExtends:           "extends",
+	itemBlock:             "block",
+	itemFilter:            "filter",
+	itemEOF:               "EOF",
 }
 
 func (i itemType) String() string {
@@ -94,15 +119,19 @@ type stateFn func(*lexer) stateFn
 
 // lexer holds the state of the scanner.
 type lexer struct {
-	name       string    // the name of the input; used only for error reports.
-	input      string    // the string being scanned.
-	leftDelim  string    // start of action.
-	rightDelim string    // end of action.
-	state      stateFn   // the next lexing function to enter.
-	pos        int       // current position in the input.
-	start      int       // start position of this item.
-	width      int       // width of last rune read from input.
-	items      chan item // channel of scanned items.
+	name       string  // the name of the input; used only for error reports.
+	input      string  // the string being scanned.
+	leftDelim  string  // start of action.
+	rightDelim string  // end of action.
+	state      stateFn // the next lexing function to enter.
+	pos        int     // current position in the input.
+	start      int     // start position of this item.
+	width      int     // width of last rune read from input.
+	items      []item  // buffered items awaiting consumption by nextItem.
+
+	line      int // 1-based line number of l.pos.
+	startLine int // 1-based line number of l.start.
+	lineStart int // byte offset of the first byte of the line containing l.pos.
 }
 
 // next returns the next rune in the input.
@@ -113,6 +142,10 @@ func (l *lexer) next() (r rune) {
 	}
 	r, l.width = utf8.DecodeRuneInString(l.input[l.pos:])
 	l.pos += l.width
+	if r == '\n' {
+		l.line++
+		l.lineStart = l.pos
+	}
 	return r
 }
 
@@ -126,17 +159,51 @@ func (l *lexer) peek() rune {
 // backup steps back one rune. Can only be called once per call of next.
 func (l *lexer) backup() {
 	l.pos -= l.width
+	if l.width == 1 && l.pos < len(l.input) && l.input[l.pos] == '\n' {
+		l.line--
+		if idx := strings.LastIndexByte(l.input[:l.pos], '\n'); idx >= 0 {
+			l.lineStart = idx + 1
+		} else {
+			l.lineStart = 0
+		}
+	}
 }
 
 // emit passes an item back to the client.
 func (l *lexer) emit(t itemType) {
-	l.items <- item{t, l.input[l.start:l.pos]}
+	col := l.start - l.lineStart + 1
+	if l.start < l.lineStart {
+		// The item ends in a newline that next has already counted.
+		col = l.start - strings.LastIndexByte(l.input[:l.start], '\n')
+	}
+	l.items = append(l.items, item{
+		typ:  t,
+		pos:  l.start,
+		val:  l.input[l.start:l.pos],
+		line: l.startLine,
+		col:  col,
+	})
 	l.start = l.pos
+	l.startLine = l.line
 }
 
 // ignore skips over the pending input before this point.
 func (l *lexer) ignore() {
 	l.start = l.pos
+	l.startLine = l.line
+}
+
+// skipMarker discards a single-character marker ('-', '=', '+', ':') at the
+// head of a tag line. lexTag usually reaches its marker cases with the
+// marker already pre-fetched into [start,pos) by lexNewLine/lexIndent's
+// read-ahead, so ignore() alone drops it; at the very start of the
+// template, where nothing has pre-fetched anything, pos still equals start
+// and the marker itself has to be consumed first.
+func (l *lexer) skipMarker() {
+	if l.pos == l.start {
+		l.next()
+	}
+	l.ignore()
 }
 
 // accept consumes the next rune if it's from the valid set.
@@ -155,30 +222,32 @@ func (l *lexer) acceptRun(valid string) {
 	l.backup()
 }
 
-// lineNumber reports which line we're on. Doing it this way
-// means we don't have to worry about peek double counting.
+// lineNumber reports which line we're on, tracked incrementally in next/backup
+// rather than recomputed from the start of input on every call.
 func (l *lexer) lineNumber() int {
-	return 1 + strings.Count(l.input[:l.pos], "\n")
+	return l.line
 }
 
 // error returns an error token and terminates the scan by passing
 // back a nil pointer that will be the next state, terminating l.nextItem.
 func (l *lexer) errorf(format string, args ...interface{}) stateFn {
-	l.items <- item{itemError, fmt.Sprintf(format, args...)}
+	l.items = append(l.items, item{typ: itemError, pos: l.start, val: fmt.Sprintf(format, args...), line: l.startLine, col: l.start - l.lineStart + 1})
 	return nil
 }
 
-// nextItem returns the next item from the input.
+// nextItem returns the next item from the input. lex never runs as a
+// goroutine, so this simply drives the state machine forward until an item
+// is buffered; it replaces what used to be an unnecessary channel handoff.
 func (l *lexer) nextItem() item {
-	for {
-		select {
-		case item := <-l.items:
-			return item
-		default:
-			l.state = l.state(l)
+	for len(l.items) == 0 {
+		if l.state == nil {
+			return item{typ: itemEOF}
 		}
+		l.state = l.state(l)
 	}
-	panic("not reached")
+	it := l.items[0]
+	l.items = l.items[1:]
+	return it
 }
 
 // lex creates a new scanner for the input string.
@@ -195,7 +264,9 @@ func lex(name, input, left, right string) *lexer {
 		leftDelim:  left,
 		rightDelim: right,
 		state:      lexTag,
-		items:      make(chan item, 2), // Two items of buffering is sufficient for all state functions
+		items:      make([]item, 0, 2), // Room for two items up front; the slice grows when a state function emits more
+		line:       1,
+		startLine:  1,
 	}
 	return l
 }
@@ -203,11 +274,18 @@ func lex(name, input, left, right string) *lexer {
 // state functions
 
 const (
-	leftDelim    = "{{"
-	rightDelim   = "}}"
-	Comment      = "//"
-	docTypeShort = "!!!"
-	docTypeLong  = "doctype"
+	leftDelim      = "{{"
+	rightDelim     = "}}"
+	Comment        = "//"
+	commentUnbuf   = "//-"
+	docTypeShort   = "!!!"
+	docTypeLong    = "doctype"
+	mixinKeyword   = "mixin"
+	includeKeyword = "include"
+	extendsKeyword = "extends"
+	blockKeyword   = "block"
+	interpLeft     = "#{"
+	interpRight    = "}"
 )
 
 // lexTag scans html tag name.
@@ -229,6 +307,18 @@ func lexTag(l *lexer) stateFn {
 		return lexText
 	case '\r', '\n':
 		return lexNewLine
+	case '-':
+		return lexCode
+	case '=':
+		return lexBufferedCode
+	case '+':
+		return lexMixinCall
+	case ':':
+		return lexFilter
+	}
+
+	if strings.HasPrefix(l.input[l.start:], commentUnbuf) {
+		return lexCommentUnbuffered
 	}
 
 	if strings.HasPrefix(l.input[l.start:], Comment) {
@@ -239,6 +329,22 @@ func lexTag(l *lexer) stateFn {
 		return lexDocType
 	}
 
+	if strings.HasPrefix(l.input[l.start:], mixinKeyword+" ") {
+		return lexMixinDef
+	}
+
+	if strings.HasPrefix(l.input[l.start:], includeKeyword+" ") {
+		return lexInclude
+	}
+
+	if strings.HasPrefix(l.input[l.start:], extendsKeyword+" ") {
+		return lexExtends
+	}
+
+	if strings.HasPrefix(l.input[l.start:], blockKeyword+" ") {
+		return lexBlock
+	}
+
 	switch r := l.peek(); {
 	case r == eof:
 		if l.pos > l.start {
@@ -258,6 +364,10 @@ func lexTag(l *lexer) stateFn {
 	case r == '.':
 		l.emit(itemTag)
 		l.next()
+		if n := l.peek(); n == '\n' || n == '\r' || n == eof {
+			l.emit(itemBlockText)
+			return lexNewLine
+		}
 		l.ignore()
 		return lexClass
 	case r == '#':
@@ -309,14 +419,17 @@ func lexId(l *lexer) stateFn {
 	case r == '(':
 		l.emit(itemId)
 		l.next()
+		l.ignore()
 		return lexAttr
 	case r == ' ':
 		l.emit(itemId)
 		l.next()
+		l.ignore()
 		return lexText
 	case r == '.':
 		l.emit(itemId)
 		l.next()
+		l.ignore()
 		return lexClass
 	case r == eof:
 		l.emit(itemId)
@@ -336,11 +449,23 @@ func lexClass(l *lexer) stateFn {
 	case r == '(':
 		l.emit(itemClass)
 		l.next()
+		l.ignore()
 		return lexAttr
 	case r == ' ':
 		l.emit(itemClass)
 		l.next()
+		l.ignore()
 		return lexText
+	case r == '.':
+		l.emit(itemClass)
+		l.next()
+		l.ignore()
+		return lexClass
+	case r == '#':
+		l.emit(itemClass)
+		l.next()
+		l.ignore()
+		return lexId
 	case r == eof:
 		l.emit(itemClass)
 		return lexEOF
@@ -355,6 +480,13 @@ func lexText(l *lexer) stateFn {
 		return lexNewLine
 	}
 
+	if strings.HasPrefix(l.input[l.pos:], interpLeft) {
+		if l.pos > l.start {
+			l.emit(itemText)
+		}
+		return lexInterpolation
+	}
+
 	switch r := l.peek(); {
 	case r == '\r' || r == '\n':
 		l.emit(itemText)
@@ -369,6 +501,21 @@ func lexText(l *lexer) stateFn {
 	return lexText
 }
 
+// lexInterpolation scans a #{expr} placeholder embedded in text.
+func lexInterpolation(l *lexer) stateFn {
+	l.pos += len(interpLeft)
+	l.ignore()
+	i := strings.Index(l.input[l.pos:], interpRight)
+	if i < 0 {
+		return l.errorf("unclosed interpolation")
+	}
+	l.pos += i
+	l.emit(itemInterpolation)
+	l.next()
+	l.ignore()
+	return lexText
+}
+
 func lexComment(l *lexer) stateFn {
 	switch r := l.peek(); {
 	case r == '\r' || r == '\n':
@@ -384,21 +531,136 @@ func lexComment(l *lexer) stateFn {
 	return lexComment
 }
 
+func lexCommentUnbuffered(l *lexer) stateFn {
+	switch r := l.peek(); {
+	case r == '\r' || r == '\n':
+		l.emit(itemCommentUnbuffered)
+		l.next()
+		return lexNewLine
+	case r == eof:
+		l.emit(itemCommentUnbuffered)
+		return lexEOF
+	default:
+		l.next()
+	}
+	return lexCommentUnbuffered
+}
+
+// lexCode scans unbuffered code: "- expr". lexTag dispatches here straight
+// off its l.input[l.start] switch, so the '-' marker is usually already
+// sitting in the pending span (one rune ahead of l.start, courtesy of
+// lexNewLine/lexIndent's read-ahead) — except at the very start of the
+// template, where nothing has primed the span yet. skipMarker accounts for
+// both.
+func lexCode(l *lexer) stateFn {
+	l.skipMarker()
+	return lexLineRest(itemCode)
+}
+
+// lexBufferedCode scans buffered code: "= expr". See lexCode for why the
+// marker is dropped via skipMarker rather than a plain next();ignore().
+func lexBufferedCode(l *lexer) stateFn {
+	l.skipMarker()
+	return lexLineRest(itemBufferedCode)
+}
+
+// lexMixinDef scans a mixin definition: "mixin name(args)".
+func lexMixinDef(l *lexer) stateFn {
+	l.pos = l.start + len(mixinKeyword) + 1
+	l.ignore()
+	return lexLineRest(itemMixinDef)
+}
+
+// lexMixinCall scans a mixin call: "+name(args)". See lexCode for why the
+// marker is dropped via skipMarker rather than a plain next();ignore().
+func lexMixinCall(l *lexer) stateFn {
+	l.skipMarker()
+	return lexLineRest(itemMixinCall)
+}
+
+// lexInclude scans "include path".
+func lexInclude(l *lexer) stateFn {
+	l.pos = l.start + len(includeKeyword) + 1
+	l.ignore()
+	return lexLineRest(itemInclude)
+}
+
+// lexExtends scans "extends path".
+func lexExtends(l *lexer) stateFn {
+	l.pos = l.start + len(extendsKeyword) + 1
+	l.ignore()
+	return lexLineRest(itemExtends)
+}
+
+// lexBlock scans "block name".
+func lexBlock(l *lexer) stateFn {
+	l.pos = l.start + len(blockKeyword) + 1
+	l.ignore()
+	return lexLineRest(itemBlock)
+}
+
+// lexFilter scans ":filter". See lexCode for why the marker is dropped via
+// skipMarker rather than a plain next();ignore().
+func lexFilter(l *lexer) stateFn {
+	l.skipMarker()
+	return lexLineRest(itemFilter)
+}
+
+// lexLineRest returns a stateFn that emits the remainder of the current
+// line as an item of the given type, then continues on the next line.
+func lexLineRest(t itemType) stateFn {
+	return func(l *lexer) stateFn {
+		switch r := l.peek(); {
+		case r == '\r' || r == '\n':
+			l.emit(t)
+			l.next()
+			return lexNewLine
+		case r == eof:
+			l.emit(t)
+			return lexEOF
+		default:
+			l.next()
+			return lexLineRest(t)(l)
+		}
+	}
+}
+
 func lexAttr(l *lexer) stateFn {
+	quote := rune(0)
+	depth := 0
 Loop:
 	for {
 		switch r := l.peek(); {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+			l.next()
+		case r == '\'' || r == '"':
+			quote = r
+			l.next()
+		case r == '(':
+			depth++
+			l.next()
 		case r == ')':
-			break Loop
-		case r == ',':
+			if depth == 0 {
+				break Loop
+			}
+			depth--
+			l.next()
+		case r == ',' && depth == 0:
 			l.emit(itemAttr)
 			l.next()
 			l.ignore()
+		case r == eof:
+			break Loop
 		default:
 			l.next()
 		}
 	}
-	l.emit(itemAttr)
+	if l.pos > l.start {
+		l.emit(itemAttr)
+	}
 	l.next()
 	l.ignore()
 	l.next()
@@ -421,6 +683,9 @@ func lexIndent(l *lexer) stateFn {
 }
 
 func lexNewLine(l *lexer) stateFn {
+	if l.start >= len(l.input) {
+		return lexEOF
+	}
 	if l.input[l.start] == '\r' || l.input[l.start] == '\n' {
 		l.emit(itemEndl)
 		l.next()
@@ -44,3 +44,63 @@ func TestJade(t *testing.T) {
 		}
 	}
 }
+
+// TestJadeTrailingNewline guards against a panic in lexNewLine: once the
+// lexer has consumed the final "\n" in the input, l.start sits at
+// len(l.input), and indexing l.input[l.start] without a bounds check blows
+// up. Virtually every real Jade file ends in a newline.
+func TestJadeTrailingNewline(t *testing.T) {
+	for _, input := range []string{"div\n", "html\n  body\n    h1 Hello\n"} {
+		l := lex("name", input, "", "")
+		for {
+			item := l.nextItem()
+			if item.typ == itemEOF || item.typ == itemError {
+				break
+			}
+		}
+	}
+}
+
+// itemsOfType returns the values of every item of type typ lexed from
+// input.
+func itemsOfType(input string, typ itemType) []string {
+	var got []string
+	l := lex("name", input, "", "")
+	for {
+		item := l.nextItem()
+		if item.typ == typ {
+			got = append(got, item.val)
+		}
+		if item.typ == itemEOF || item.typ == itemError {
+			break
+		}
+	}
+	return got
+}
+
+// TestLexMarkerLineAfterOtherLine guards against lexCode, lexBufferedCode,
+// lexMixinCall, and lexFilter dropping the wrong byte when their '-'/'='/
+// '+'/':' marker line isn't the first line of the template: a preceding
+// line leaves lexNewLine/lexIndent's read-ahead sitting one byte into the
+// marker already, so these lexers must discard exactly that one byte, not
+// the byte after it.
+func TestLexMarkerLineAfterOtherLine(t *testing.T) {
+	cases := []struct {
+		input string
+		typ   itemType
+		want  string
+	}{
+		// itemCode/itemBufferedCode keep the space between the marker and
+		// the expression; the parser trims it later (see parseLine).
+		{"p one\n- expr\n", itemCode, " expr"},
+		{"p one\n= expr\n", itemBufferedCode, " expr"},
+		{"p one\n+greet\n", itemMixinCall, "greet"},
+		{"p one\n:plain\n", itemFilter, "plain"},
+	}
+	for _, c := range cases {
+		got := itemsOfType(c.input, c.typ)
+		if len(got) != 1 || got[0] != c.want {
+			t.Errorf("lex(%q) %s = %v, want [%q]", c.input, c.typ, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2013 Benjamin Gentil
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// HTML minification.
+
+package jade
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// voidElements is the HTML5 set of elements that never have a closing tag.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"param": true, "source": true, "track": true, "wbr": true,
+}
+
+// booleanAttrs is the set of HTML attributes whose presence alone conveys
+// their meaning; gojade renders them without a value.
+var booleanAttrs = map[string]bool{
+	"disabled": true, "checked": true, "selected": true, "readonly": true,
+	"multiple": true, "required": true, "autofocus": true, "autoplay": true,
+	"controls": true, "loop": true, "default": true, "hidden": true, "open": true,
+}
+
+// rawTextElements hold literal content that must not be whitespace-collapsed.
+var rawTextElements = map[string]bool{
+	"pre": true, "script": true, "style": true, "textarea": true,
+}
+
+var unquotedAttrValue = regexp.MustCompile(`^[A-Za-z0-9._:-]+$`)
+var whitespaceRun = regexp.MustCompile(`\s+`)
+
+// Minify walks n and writes a minified HTML rendering to w: void elements
+// are not given a closing tag, runs of whitespace in text collapse to a
+// single space outside <pre>/<script>/<style>/<textarea>, attribute values
+// are unquoted where safe, and boolean attributes are written without a
+// value.
+func Minify(w io.Writer, n Node) error {
+	m := &minifier{w: w}
+	m.write(n, false)
+	return m.err
+}
+
+// RenderMinified writes a minified HTML rendering of the tree to w.
+func (t *Tree) RenderMinified(w io.Writer) error {
+	return Minify(w, t.Root)
+}
+
+type minifier struct {
+	w   io.Writer
+	err error
+}
+
+func (m *minifier) printf(format string, args ...interface{}) {
+	if m.err != nil {
+		return
+	}
+	_, m.err = fmt.Fprintf(m.w, format, args...)
+}
+
+func (m *minifier) write(n Node, rawText bool) {
+	if m.err != nil || n == nil {
+		return
+	}
+	switch t := n.(type) {
+	case *ListNode:
+		for _, c := range t.Nodes {
+			m.write(c, rawText)
+		}
+	case *TagNode:
+		m.writeTag(t, rawText)
+	case *TextNode:
+		if rawText {
+			m.printf("%s", t.Text)
+		} else {
+			m.printf("%s", collapseWhitespace(string(t.Text)))
+		}
+	case *BlockNode:
+		for _, c := range t.Nodes {
+			m.write(c, rawText)
+		}
+	default:
+		// Everything else (doctype, comments, interpolation, code, mixins,
+		// includes, filters, ...) renders through its own HTMLString.
+		m.printf("%s", n.HTMLString())
+	}
+}
+
+func (m *minifier) writeTag(t *TagNode, rawText bool) {
+	name := string(t.Tag)
+	m.printf("<%s", name)
+
+	var classes []string
+	for _, n := range t.Nodes {
+		switch c := n.(type) {
+		case *ClassNode:
+			classes = append(classes, c.String())
+		case *IdNode:
+			m.writeAttr("id", c.String())
+		case *AttrNode:
+			// class= merges into the same class list as .dotclass sugar and
+			// any other class= attribute, matching TagNode.Render, so only
+			// one class="..." is ever emitted.
+			if c.Name == "class" {
+				classes = append(classes, c.Value)
+				continue
+			}
+			if c.Value == "" {
+				m.writeAttr(c.Name, c.Name)
+			} else {
+				m.writeAttr(c.Name, c.Value)
+			}
+		}
+	}
+	if len(classes) > 0 {
+		m.writeAttr("class", strings.Join(classes, " "))
+	}
+	m.printf(">")
+
+	void := voidElements[strings.ToLower(name)]
+	if !void {
+		rawText = rawText || rawTextElements[strings.ToLower(name)]
+		for _, n := range t.Nodes {
+			switch n.Type() {
+			case NodeClass, NodeAttr, NodeId:
+				// already folded into the opening tag above
+			default:
+				m.write(n, rawText)
+			}
+		}
+		m.printf("</%s>", name)
+	}
+}
+
+// writeAttr writes a single name/value attribute, omitting the value for
+// boolean attributes and the quotes when value needs none.
+func (m *minifier) writeAttr(name, value string) {
+	if booleanAttrs[strings.ToLower(name)] {
+		m.printf(" %s", name)
+		return
+	}
+	if unquotedAttrValue.MatchString(value) {
+		m.printf(" %s=%s", name, value)
+		return
+	}
+	m.printf(` %s="%s"`, name, value)
+}
+
+// collapseWhitespace reduces any run of whitespace to a single space.
+func collapseWhitespace(s string) string {
+	return whitespaceRun.ReplaceAllString(s, " ")
+}
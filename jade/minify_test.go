@@ -0,0 +1,29 @@
+// Copyright 2013 Benjamin Gentil
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jade
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestMinifyMergesDuplicateClassAttr guards against Minify emitting two
+// class="..." attributes for a tag that mixes .dotclass sugar with an
+// explicit class= attribute; TagNode.Render already merges these into one.
+func TestMinifyMergesDuplicateClassAttr(t *testing.T) {
+	tree, err := New("name").Parse(`div.a(class="b")`+"\n", "", "", make(map[string]*Tree), nil)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var b bytes.Buffer
+	if err := Minify(&b, tree.Root); err != nil {
+		t.Fatalf("Minify: %v", err)
+	}
+	want := `<div class="a b"></div>`
+	if b.String() != want {
+		t.Errorf("Minify = %q, want %q", b.String(), want)
+	}
+}
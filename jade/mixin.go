@@ -0,0 +1,82 @@
+// Copyright 2013 Benjamin Gentil
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Mixin call expansion.
+
+package jade
+
+// expandMixins rewrites every mixin call ("+name(args)") found anywhere
+// under n into a copy of the matching mixin definition's body, so Render
+// doesn't silently drop calls. A call to an undefined mixin expands to
+// nothing. Mixin definitions themselves are left in place; they already
+// render as "".
+func expandMixins(n Node) {
+	defs := map[string]*MixinDefNode{}
+	collectMixinDefs(n, defs)
+	replaceMixinCalls(n, defs)
+}
+
+// collectMixinDefs indexes every mixin definition under n by name.
+func collectMixinDefs(n Node, defs map[string]*MixinDefNode) {
+	switch t := n.(type) {
+	case *ListNode:
+		for _, c := range t.Nodes {
+			collectMixinDefs(c, defs)
+		}
+	case *TagNode:
+		for _, c := range t.Nodes {
+			collectMixinDefs(c, defs)
+		}
+	case *BlockNode:
+		for _, c := range t.Nodes {
+			collectMixinDefs(c, defs)
+		}
+	case *MixinDefNode:
+		defs[t.Name] = t
+		for _, c := range t.Nodes {
+			collectMixinDefs(c, defs)
+		}
+	}
+}
+
+// replaceMixinCalls walks n's children, substituting a copy of the matching
+// definition's body for every *MixinCallNode it finds.
+func replaceMixinCalls(n Node, defs map[string]*MixinDefNode) {
+	switch t := n.(type) {
+	case *ListNode:
+		t.Nodes = expandChildren(t.Nodes, defs)
+	case *TagNode:
+		t.Nodes = expandChildren(t.Nodes, defs)
+	case *BlockNode:
+		t.Nodes = expandChildren(t.Nodes, defs)
+	case *MixinDefNode:
+		t.Nodes = expandChildren(t.Nodes, defs)
+	}
+}
+
+// expandChildren returns nodes with every mixin call replaced by a copy of
+// its definition's body, recursing into containers first so a mixin called
+// from inside another mixin's body also resolves.
+func expandChildren(nodes []Node, defs map[string]*MixinDefNode) []Node {
+	out := make([]Node, 0, len(nodes))
+	for _, c := range nodes {
+		call, ok := c.(*MixinCallNode)
+		if !ok {
+			replaceMixinCalls(c, defs)
+			out = append(out, c)
+			continue
+		}
+		def, ok := defs[call.Name]
+		if !ok {
+			continue
+		}
+		for _, body := range def.Nodes {
+			cp := body.Copy()
+			replaceMixinCalls(cp, defs)
+			out = append(out, cp)
+		}
+	}
+	return out
+}
@@ -11,9 +11,9 @@ package jade
 import (
 	"bytes"
 	"fmt"
-
-//	"strconv"
-//	"strings"
+	"io"
+	//	"strconv"
+	"strings"
 )
 
 var doctypes = map[string]string{
@@ -33,6 +33,10 @@ type Node interface {
 	Type() NodeType
 	String() string
 	HTMLString() string
+	// Render writes the node's HTML representation directly to w, so that
+	// rendering a deep tree costs one pass instead of building and
+	// recopying an intermediate string at every level.
+	Render(w io.Writer) error
 	// Copy does a deep copy of the Node and all its components.
 	// To avoid type assertions, some XxxNodes also have specialized
 	// CopyXxx methods that return *XxxNode.
@@ -56,16 +60,40 @@ const (
 	NodeId
 	NodeClass
 	NodeList
+	NodeBlockText
+	NodeInterpolation
+	NodeCode
+	NodeBufferedCode
+	NodeComment
+	NodeCommentUnbuffered
+	NodeMixinDef
+	NodeMixinCall
+	NodeInclude
+	NodeExtends
+	NodeBlock
+	NodeFilter
 )
 
 var nodeName = map[NodeType]string{
-	NodeText:    "text",
-	NodeTag:     "tag",
-	NodeAttr:    "attr",
-	NodeDoctype: "doctype",
-	NodeId:      "id",
-	NodeClass:   "class",
-	NodeList:    "List",
+	NodeText:              "text",
+	NodeTag:               "tag",
+	NodeAttr:              "attr",
+	NodeDoctype:           "doctype",
+	NodeId:                "id",
+	NodeClass:             "class",
+	NodeList:              "List",
+	NodeBlockText:         "blockText",
+	NodeInterpolation:     "interpolation",
+	NodeCode:              "code",
+	NodeBufferedCode:      "bufferedCode",
+	NodeComment:           "comment",
+	NodeCommentUnbuffered: "commentUnbuffered",
+	NodeMixinDef:          "mixinDef",
+	NodeMixinCall:         "mixinCall",
+	NodeInclude:           "include",
+	NodeExtends:           "extends",
+	NodeBlock:             "block",
+	NodeFilter:            "filter",
 }
 
 func (i NodeType) String() string {
@@ -101,11 +129,18 @@ func (l *ListNode) String() string {
 }
 
 func (l *ListNode) HTMLString() string {
-	b := new(bytes.Buffer)
+	var b bytes.Buffer
+	l.Render(&b)
+	return b.String()
+}
+
+func (l *ListNode) Render(w io.Writer) error {
 	for _, n := range l.Nodes {
-		fmt.Fprint(b, n.HTMLString())
+		if err := n.Render(w); err != nil {
+			return err
+		}
 	}
-	return b.String()
+	return nil
 }
 
 func (l *ListNode) CopyList() *ListNode {
@@ -143,42 +178,70 @@ func (l *TagNode) String() string {
 }
 
 func (l *TagNode) HTMLString() string {
-	b := new(bytes.Buffer)
+	var b bytes.Buffer
+	l.Render(&b)
+	return b.String()
+}
+
+func (l *TagNode) Render(w io.Writer) error {
 	n_classes := 0
 	classes := new(bytes.Buffer)
-	fmt.Fprint(b, fmt.Sprintf("<%s", l.Tag))
+	var id string
+	hasID := false
+	var attrs []*AttrNode
+
 	for _, n := range l.Nodes {
-		if n.Type() == NodeClass {
+		switch t := n.(type) {
+		case *ClassNode:
 			if n_classes > 0 {
 				fmt.Fprint(classes, " ")
 			}
-			fmt.Fprintf(classes, "%s", n.HTMLString())
+			fmt.Fprintf(classes, "%s", t.Class)
 			n_classes++
-		}
-		if n.Type() == NodeAttr {
-			fmt.Fprintf(b, " %s", n.HTMLString())
-		}
-		if n.Type() == NodeId {
-			fmt.Fprintf(b, " id=\"%s\"", n.HTMLString())
+		case *IdNode:
+			id = string(t.Id)
+			hasID = true
+		case *AttrNode:
+			// class= merges into the same class list as .dotclass sugar and
+			// any other class= attribute, instead of being duplicated.
+			if t.Name == "class" {
+				if n_classes > 0 {
+					fmt.Fprint(classes, " ")
+				}
+				fmt.Fprint(classes, t.Value)
+				n_classes++
+				continue
+			}
+			attrs = append(attrs, t)
 		}
 	}
 
-	if len(classes.String()) > 0 {
-		fmt.Fprintf(b, " class=\"%s\"", classes.String())
+	fmt.Fprintf(w, "<%s", l.Tag)
+	for _, a := range attrs {
+		fmt.Fprintf(w, " %s", a.HTMLString())
+	}
+	if hasID {
+		fmt.Fprintf(w, " id=\"%s\"", id)
+	}
+	if classes.Len() > 0 {
+		fmt.Fprintf(w, " class=\"%s\"", classes.String())
 	}
 
-	fmt.Fprint(b, ">")
+	fmt.Fprint(w, ">")
 
 	for _, n := range l.Nodes {
-		if n.Type() == NodeText || n.Type() == NodeTag {
-			fmt.Fprint(b, n.HTMLString())
+		switch n.Type() {
+		case NodeClass, NodeAttr, NodeId:
+			// already folded into the opening tag above
+		default:
+			if err := n.Render(w); err != nil {
+				return err
+			}
 		}
 	}
 
-	fmt.Fprintf(b, "</%s>", l.Tag)
-	return b.String()
-
-	return fmt.Sprintf("%s", l.Tag)
+	_, err := fmt.Fprintf(w, "</%s>", l.Tag)
+	return err
 }
 
 func (l *TagNode) CopyTag() *TagNode {
@@ -214,6 +277,11 @@ func (t *TextNode) HTMLString() string {
 	return t.String()
 }
 
+func (t *TextNode) Render(w io.Writer) error {
+	_, err := w.Write(t.Text)
+	return err
+}
+
 func (t *TextNode) Copy() Node {
 	return &TextNode{NodeType: NodeText, Text: append([]byte{}, t.Text...)}
 }
@@ -240,30 +308,66 @@ func (t *DoctypeNode) HTMLString() string {
 	return fmt.Sprintf("<!DOCTYPE %s >", t.Doctype)
 }
 
+func (t *DoctypeNode) Render(w io.Writer) error {
+	_, err := io.WriteString(w, t.HTMLString())
+	return err
+}
+
 func (t *DoctypeNode) Copy() Node {
 	return &DoctypeNode{NodeType: NodeDoctype, Doctype: append([]byte{}, t.Doctype...)}
 }
 
-// AttrNode
+// AttrNode holds a single parsed "name=value" (or bare "name") attribute,
+// as produced by lexAttr's quote- and paren-aware state machine.
 type AttrNode struct {
 	NodeType
-	Attr []byte
+	Name  string
+	Value string
+	Quote byte // the quote byte used in the source ('\'' or '"'), or 0 if bare/unquoted.
 }
 
+// newAttr parses a raw attribute token into its name, value, and quoting.
 func newAttr(attr string) *AttrNode {
-	return &AttrNode{NodeType: NodeAttr, Attr: []byte(attr)}
+	attr = strings.TrimSpace(attr)
+	i := strings.IndexByte(attr, '=')
+	if i < 0 {
+		return &AttrNode{NodeType: NodeAttr, Name: attr}
+	}
+	name := strings.TrimSpace(attr[:i])
+	value := strings.TrimSpace(attr[i+1:])
+	var quote byte
+	if len(value) >= 2 && (value[0] == '\'' || value[0] == '"') && value[len(value)-1] == value[0] {
+		quote = value[0]
+		value = value[1 : len(value)-1]
+	}
+	return &AttrNode{NodeType: NodeAttr, Name: name, Value: value, Quote: quote}
 }
 
 func (t *AttrNode) String() string {
-	return fmt.Sprintf("%s", t.Attr)
+	if t.Value == "" {
+		return t.Name
+	}
+	return fmt.Sprintf("%s=%s", t.Name, t.Value)
 }
 
 func (t *AttrNode) HTMLString() string {
-	return t.String()
+	if t.Value == "" {
+		return t.Name
+	}
+	quote := byte('"')
+	if t.Quote != 0 {
+		quote = t.Quote
+	}
+	return fmt.Sprintf("%s=%c%s%c", t.Name, quote, t.Value, quote)
+}
+
+func (t *AttrNode) Render(w io.Writer) error {
+	_, err := io.WriteString(w, t.HTMLString())
+	return err
 }
 
 func (t *AttrNode) Copy() Node {
-	return &AttrNode{NodeType: NodeAttr, Attr: append([]byte{}, t.Attr...)}
+	return &AttrNode{NodeType: NodeAttr, Name: t.Name, Value: t.Value, Quote: t.Quote}
 }
 
 // IdNode
@@ -284,6 +388,11 @@ func (t *IdNode) HTMLString() string {
 	return t.String()
 }
 
+func (t *IdNode) Render(w io.Writer) error {
+	_, err := w.Write(t.Id)
+	return err
+}
+
 func (t *IdNode) Copy() Node {
 	return &IdNode{NodeType: NodeId, Id: append([]byte{}, t.Id...)}
 }
@@ -306,6 +415,363 @@ func (t *ClassNode) HTMLString() string {
 	return t.String()
 }
 
+func (t *ClassNode) Render(w io.Writer) error {
+	_, err := w.Write(t.Class)
+	return err
+}
+
 func (t *ClassNode) Copy() Node {
 	return &ClassNode{NodeType: NodeClass, Class: append([]byte{}, t.Class...)}
 }
+
+// BlockTextNode holds the literal, unescaped text of a dot-block (tag.).
+type BlockTextNode struct {
+	NodeType
+	Text []byte
+}
+
+func newBlockText(text string) *BlockTextNode {
+	return &BlockTextNode{NodeType: NodeBlockText, Text: []byte(text)}
+}
+
+func (t *BlockTextNode) String() string {
+	return fmt.Sprintf("%s", t.Text)
+}
+
+func (t *BlockTextNode) HTMLString() string {
+	return t.String()
+}
+
+func (t *BlockTextNode) Render(w io.Writer) error {
+	_, err := w.Write(t.Text)
+	return err
+}
+
+func (t *BlockTextNode) Copy() Node {
+	return &BlockTextNode{NodeType: NodeBlockText, Text: append([]byte{}, t.Text...)}
+}
+
+// InterpolationNode holds a #{expr} placeholder found inside text.
+type InterpolationNode struct {
+	NodeType
+	Expr []byte
+}
+
+func newInterpolation(expr string) *InterpolationNode {
+	return &InterpolationNode{NodeType: NodeInterpolation, Expr: []byte(expr)}
+}
+
+func (t *InterpolationNode) String() string {
+	return fmt.Sprintf("#{%s}", t.Expr)
+}
+
+func (t *InterpolationNode) HTMLString() string {
+	return fmt.Sprintf("%s", t.Expr)
+}
+
+func (t *InterpolationNode) Render(w io.Writer) error {
+	_, err := w.Write(t.Expr)
+	return err
+}
+
+func (t *InterpolationNode) Copy() Node {
+	return &InterpolationNode{NodeType: NodeInterpolation, Expr: append([]byte{}, t.Expr...)}
+}
+
+// CodeNode holds unbuffered code ("- expr"); it produces no output of its own.
+type CodeNode struct {
+	NodeType
+	Code []byte
+}
+
+func newCode(code string) *CodeNode {
+	return &CodeNode{NodeType: NodeCode, Code: []byte(code)}
+}
+
+func (t *CodeNode) String() string {
+	return fmt.Sprintf("%s", t.Code)
+}
+
+func (t *CodeNode) HTMLString() string {
+	return ""
+}
+
+func (t *CodeNode) Render(w io.Writer) error {
+	return nil
+}
+
+func (t *CodeNode) Copy() Node {
+	return &CodeNode{NodeType: NodeCode, Code: append([]byte{}, t.Code...)}
+}
+
+// BufferedCodeNode holds buffered code ("= expr"). Render writes the
+// expression's source text to the output; the expression is not evaluated.
+type BufferedCodeNode struct {
+	NodeType
+	Code []byte
+}
+
+func newBufferedCode(code string) *BufferedCodeNode {
+	return &BufferedCodeNode{NodeType: NodeBufferedCode, Code: []byte(code)}
+}
+
+func (t *BufferedCodeNode) String() string {
+	return fmt.Sprintf("%s", t.Code)
+}
+
+func (t *BufferedCodeNode) HTMLString() string {
+	return fmt.Sprintf("%s", t.Code)
+}
+
+func (t *BufferedCodeNode) Render(w io.Writer) error {
+	_, err := w.Write(t.Code)
+	return err
+}
+
+func (t *BufferedCodeNode) Copy() Node {
+	return &BufferedCodeNode{NodeType: NodeBufferedCode, Code: append([]byte{}, t.Code...)}
+}
+
+// CommentNode holds a buffered comment (//), rendered as an HTML comment.
+type CommentNode struct {
+	NodeType
+	Text []byte
+}
+
+func newComment(text string) *CommentNode {
+	return &CommentNode{NodeType: NodeComment, Text: []byte(text)}
+}
+
+func (t *CommentNode) String() string {
+	return fmt.Sprintf("%s", t.Text)
+}
+
+func (t *CommentNode) HTMLString() string {
+	return fmt.Sprintf("<!--%s-->", t.Text)
+}
+
+func (t *CommentNode) Render(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "<!--%s-->", t.Text)
+	return err
+}
+
+func (t *CommentNode) Copy() Node {
+	return &CommentNode{NodeType: NodeComment, Text: append([]byte{}, t.Text...)}
+}
+
+// CommentUnbufferedNode holds an unbuffered comment (//-); it is dropped
+// entirely from the rendered output.
+type CommentUnbufferedNode struct {
+	NodeType
+	Text []byte
+}
+
+func newCommentUnbuffered(text string) *CommentUnbufferedNode {
+	return &CommentUnbufferedNode{NodeType: NodeCommentUnbuffered, Text: []byte(text)}
+}
+
+func (t *CommentUnbufferedNode) String() string {
+	return fmt.Sprintf("%s", t.Text)
+}
+
+func (t *CommentUnbufferedNode) HTMLString() string {
+	return ""
+}
+
+func (t *CommentUnbufferedNode) Render(w io.Writer) error {
+	return nil
+}
+
+func (t *CommentUnbufferedNode) Copy() Node {
+	return &CommentUnbufferedNode{NodeType: NodeCommentUnbuffered, Text: append([]byte{}, t.Text...)}
+}
+
+// MixinDefNode holds a mixin definition ("mixin name(args)") and its body.
+type MixinDefNode struct {
+	NodeType
+	Name  string
+	Args  []byte
+	Nodes []Node
+}
+
+func newMixinDef(name, args string) *MixinDefNode {
+	return &MixinDefNode{NodeType: NodeMixinDef, Name: name, Args: []byte(args)}
+}
+
+func (l *MixinDefNode) append(n Node) {
+	l.Nodes = append(l.Nodes, n)
+}
+
+func (l *MixinDefNode) String() string {
+	return fmt.Sprintf("mixin %s(%s)", l.Name, l.Args)
+}
+
+func (l *MixinDefNode) HTMLString() string {
+	return ""
+}
+
+func (l *MixinDefNode) Render(w io.Writer) error {
+	return nil
+}
+
+func (l *MixinDefNode) Copy() Node {
+	n := newMixinDef(l.Name, string(l.Args))
+	for _, elem := range l.Nodes {
+		n.append(elem.Copy())
+	}
+	return n
+}
+
+// MixinCallNode holds a mixin call ("+name(args)"). Tree.Parse replaces
+// every MixinCallNode it finds with a copy of the named mixin's body via
+// expandMixins, and removes calls to undefined mixins, so this reaches a
+// rendered tree only when the node was built by hand without going through
+// Parse; it then renders as nothing rather than the literal call syntax.
+type MixinCallNode struct {
+	NodeType
+	Name string
+	Args []byte
+}
+
+func newMixinCall(name, args string) *MixinCallNode {
+	return &MixinCallNode{NodeType: NodeMixinCall, Name: name, Args: []byte(args)}
+}
+
+func (t *MixinCallNode) String() string {
+	return fmt.Sprintf("+%s(%s)", t.Name, t.Args)
+}
+
+func (t *MixinCallNode) HTMLString() string {
+	return ""
+}
+
+func (t *MixinCallNode) Render(w io.Writer) error {
+	return nil
+}
+
+func (t *MixinCallNode) Copy() Node {
+	return &MixinCallNode{NodeType: NodeMixinCall, Name: t.Name, Args: append([]byte{}, t.Args...)}
+}
+
+// IncludeNode holds the path of an "include path" directive.
+type IncludeNode struct {
+	NodeType
+	Path []byte
+}
+
+func newInclude(path string) *IncludeNode {
+	return &IncludeNode{NodeType: NodeInclude, Path: []byte(path)}
+}
+
+func (t *IncludeNode) String() string {
+	return fmt.Sprintf("include %s", t.Path)
+}
+
+func (t *IncludeNode) HTMLString() string {
+	return ""
+}
+
+func (t *IncludeNode) Render(w io.Writer) error {
+	return nil
+}
+
+func (t *IncludeNode) Copy() Node {
+	return &IncludeNode{NodeType: NodeInclude, Path: append([]byte{}, t.Path...)}
+}
+
+// ExtendsNode holds the path of an "extends path" directive.
+type ExtendsNode struct {
+	NodeType
+	Path []byte
+}
+
+func newExtends(path string) *ExtendsNode {
+	return &ExtendsNode{NodeType: NodeExtends, Path: []byte(path)}
+}
+
+func (t *ExtendsNode) String() string {
+	return fmt.Sprintf("extends %s", t.Path)
+}
+
+func (t *ExtendsNode) HTMLString() string {
+	return ""
+}
+
+func (t *ExtendsNode) Render(w io.Writer) error {
+	return nil
+}
+
+func (t *ExtendsNode) Copy() Node {
+	return &ExtendsNode{NodeType: NodeExtends, Path: append([]byte{}, t.Path...)}
+}
+
+// BlockNode holds a named, overridable block ("block name") and its
+// default content.
+type BlockNode struct {
+	NodeType
+	Name  string
+	Nodes []Node
+}
+
+func newBlock(name string) *BlockNode {
+	return &BlockNode{NodeType: NodeBlock, Name: name}
+}
+
+func (l *BlockNode) append(n Node) {
+	l.Nodes = append(l.Nodes, n)
+}
+
+func (l *BlockNode) String() string {
+	return fmt.Sprintf("block %s", l.Name)
+}
+
+func (l *BlockNode) HTMLString() string {
+	var b bytes.Buffer
+	l.Render(&b)
+	return b.String()
+}
+
+func (l *BlockNode) Render(w io.Writer) error {
+	for _, n := range l.Nodes {
+		if err := n.Render(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *BlockNode) Copy() Node {
+	n := newBlock(l.Name)
+	for _, elem := range l.Nodes {
+		n.append(elem.Copy())
+	}
+	return n
+}
+
+// FilterNode holds a filter directive (":filter") and its raw text body.
+type FilterNode struct {
+	NodeType
+	Name string
+	Text []byte
+}
+
+func newFilter(name, text string) *FilterNode {
+	return &FilterNode{NodeType: NodeFilter, Name: name, Text: []byte(text)}
+}
+
+func (t *FilterNode) String() string {
+	return fmt.Sprintf(":%s %s", t.Name, t.Text)
+}
+
+func (t *FilterNode) HTMLString() string {
+	return fmt.Sprintf("%s", t.Text)
+}
+
+func (t *FilterNode) Render(w io.Writer) error {
+	_, err := w.Write(t.Text)
+	return err
+}
+
+func (t *FilterNode) Copy() Node {
+	return &FilterNode{NodeType: NodeFilter, Name: t.Name, Text: append([]byte{}, t.Text...)}
+}
@@ -0,0 +1,418 @@
+// Copyright 2011 The Go Authors.
+// 2013 Benjamin Gentil
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Parsing.
+
+package jade
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// debugEnabled turns on verbose diagnostic output during parsing.
+var debugEnabled bool
+
+// EnableDebug turns on verbose diagnostic output for subsequent parses.
+func EnableDebug() {
+	debugEnabled = true
+}
+
+// ParseError describes a single malformed line. It carries enough context
+// to point a user at the offending source, the way a compiler error does.
+type ParseError struct {
+	Name    string // name of the template in which the error occurred
+	Line    int
+	Col     int
+	Context string // the offending source line
+	Msg     string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s\n%s\n%s^", e.Name, e.Line, e.Col, e.Msg, e.Context, strings.Repeat(" ", e.Col-1))
+}
+
+// MultiError collects every ParseError found while parsing a template. A
+// line the parser rejects does not abort the rest of the parse; it is
+// recorded here and parsing resumes at the next line. A lexer error (such as
+// an unclosed interpolation) is recorded too, but it ends the token stream,
+// so nothing after it is parsed.
+type MultiError []*ParseError
+
+func (m MultiError) Error() string {
+	b := new(bytes.Buffer)
+	for i, e := range m {
+		if i > 0 {
+			fmt.Fprintln(b)
+		}
+		fmt.Fprint(b, e.Error())
+	}
+	return b.String()
+}
+
+// Tree is the representation of a single parsed Jade template.
+type Tree struct {
+	Name      string    // name of the template represented by the tree.
+	ParseName string    // name of the top-level template during parsing, for error messages.
+	Root      *ListNode // top-level root of the tree.
+
+	text string // text parsed to create the template (or its parent)
+
+	funcs   []map[string]interface{}
+	treeSet map[string]*Tree
+
+	tokens []item // the full token stream, produced once up front by the lexer.
+	pos    int    // position of the next unread token in tokens.
+
+	errors MultiError
+}
+
+// New allocates a new, unparsed tree with the given name.
+func New(name string, funcs ...map[string]interface{}) *Tree {
+	return &Tree{
+		Name:  name,
+		funcs: funcs,
+	}
+}
+
+// next returns the next token in the stream, advancing the cursor.
+func (t *Tree) next() item {
+	if t.pos >= len(t.tokens) {
+		return item{typ: itemEOF}
+	}
+	it := t.tokens[t.pos]
+	t.pos++
+	return it
+}
+
+// backup steps the cursor back one token.
+func (t *Tree) backup() {
+	if t.pos > 0 {
+		t.pos--
+	}
+}
+
+// peek returns but does not consume the next token.
+func (t *Tree) peek() item {
+	if t.pos >= len(t.tokens) {
+		return item{typ: itemEOF}
+	}
+	return t.tokens[t.pos]
+}
+
+// errorf records a parse error at the given token's position.
+func (t *Tree) errorf(it item, format string, args ...interface{}) {
+	t.errors = append(t.errors, &ParseError{
+		Name:    t.ParseName,
+		Line:    it.line,
+		Col:     it.col,
+		Context: t.lineContext(it),
+		Msg:     fmt.Sprintf(format, args...),
+	})
+}
+
+// lineContext returns the raw source line containing it, for display
+// alongside the caret in a ParseError.
+func (t *Tree) lineContext(it item) string {
+	start := strings.LastIndexByte(t.text[:it.pos], '\n') + 1
+	if end := strings.IndexByte(t.text[it.pos:], '\n'); end >= 0 {
+		return t.text[start : it.pos+end]
+	}
+	return t.text[start:]
+}
+
+// sync discards tokens up to and including the next itemEndl or itemEOF, so
+// that one malformed line does not abort the rest of the parse.
+func (t *Tree) sync() {
+	for {
+		it := t.next()
+		if it.typ == itemEndl || it.typ == itemEOF {
+			return
+		}
+	}
+}
+
+// appender is implemented by every Node that can hold child nodes; the
+// indentation-based parser uses it to decide where deeper-indented lines
+// attach.
+type appender interface {
+	append(Node)
+}
+
+// indentFrame records the node that newly parsed lines should attach to
+// while the current line's indent is deeper than indent.
+type indentFrame struct {
+	indent int
+	node   appender
+}
+
+// Parse parses the Jade template text and installs the result in t,
+// returning t and a MultiError describing every malformed line found (nil
+// if the template is clean). leftDelim and rightDelim are accepted for API
+// symmetry with text/template's parser but are unused by the Jade grammar.
+func (t *Tree) Parse(text, leftDelim, rightDelim string, treeSet map[string]*Tree, funcs ...map[string]interface{}) (tree *Tree, err error) {
+	t.text = text
+	t.treeSet = treeSet
+	t.ParseName = t.Name
+	t.funcs = append(t.funcs, funcs...)
+
+	l := lex(t.Name, text, leftDelim, rightDelim)
+	for {
+		it := l.nextItem()
+		if debugEnabled {
+			fmt.Printf("%s:%d:%d: %s\n", t.Name, it.line, it.col, it)
+		}
+		if it.typ == itemError {
+			t.errorf(it, "%s", it.val)
+			break
+		}
+		t.tokens = append(t.tokens, it)
+		if it.typ == itemEOF {
+			break
+		}
+	}
+
+	t.Root = newList()
+	stack := []indentFrame{{indent: -1, node: t.Root}}
+	var prevNode Node
+	prevIndent := -1
+
+	for t.peek().typ != itemEOF {
+		indent, ok := t.consumeIndent()
+		if !ok {
+			break
+		}
+		if t.peek().typ == itemEndl {
+			t.next()
+			continue
+		}
+
+		if indent > prevIndent && prevNode != nil {
+			if a, ok := prevNode.(appender); ok {
+				stack = append(stack, indentFrame{indent: prevIndent, node: a})
+			}
+		} else {
+			for len(stack) > 1 && stack[len(stack)-1].indent >= indent {
+				stack = stack[:len(stack)-1]
+			}
+		}
+
+		node := t.parseLine(indent)
+		if node == nil {
+			continue
+		}
+		stack[len(stack)-1].node.append(node)
+		prevNode = node
+		prevIndent = indent
+	}
+
+	expandMixins(t.Root)
+
+	if len(t.errors) > 0 {
+		return t, t.errors
+	}
+	return t, nil
+}
+
+// consumeIndent consumes the leading run of itemIdentSpace/itemIdentTab
+// tokens at the cursor and returns its length. ok is false at end of input.
+func (t *Tree) consumeIndent() (indent int, ok bool) {
+	for {
+		switch t.peek().typ {
+		case itemIdentSpace, itemIdentTab:
+			t.next()
+			indent++
+		case itemEOF:
+			return indent, false
+		default:
+			return indent, true
+		}
+	}
+}
+
+// parseLine parses the content of a single logical line at the given
+// indent and returns the Node it produces, or nil for a blank line or a
+// line that failed to parse.
+func (t *Tree) parseLine(indent int) Node {
+	it := t.peek()
+	switch it.typ {
+	case itemEndl:
+		t.next()
+		return nil
+	case itemTag:
+		return t.parseTag(indent)
+	case itemDoctype:
+		t.next()
+		t.expectEndl()
+		return newDoctype(it.val)
+	case itemComment:
+		t.next()
+		t.expectEndl()
+		return newComment(strings.TrimSpace(strings.TrimPrefix(it.val, Comment)))
+	case itemCommentUnbuffered:
+		t.next()
+		t.expectEndl()
+		return newCommentUnbuffered(strings.TrimSpace(strings.TrimPrefix(it.val, commentUnbuf)))
+	case itemCode:
+		t.next()
+		t.expectEndl()
+		return newCode(strings.TrimSpace(it.val))
+	case itemBufferedCode:
+		t.next()
+		t.expectEndl()
+		return newBufferedCode(strings.TrimSpace(it.val))
+	case itemMixinDef:
+		return t.parseMixinDef()
+	case itemMixinCall:
+		return t.parseMixinCall()
+	case itemInclude:
+		t.next()
+		t.expectEndl()
+		return newInclude(strings.TrimSpace(it.val))
+	case itemExtends:
+		t.next()
+		t.expectEndl()
+		return newExtends(strings.TrimSpace(it.val))
+	case itemBlock:
+		t.next()
+		t.expectEndl()
+		return newBlock(strings.TrimSpace(it.val))
+	case itemFilter:
+		t.next()
+		t.expectEndl()
+		return newFilter(strings.TrimSpace(it.val), t.parseRawBlock(indent))
+	case itemText, itemInterpolation:
+		list := newList()
+		t.parseInline(list)
+		t.expectEndl()
+		if len(list.Nodes) == 1 {
+			return list.Nodes[0]
+		}
+		return list
+	default:
+		t.errorf(it, "unexpected %s", it)
+		t.sync()
+		return nil
+	}
+}
+
+// expectEndl consumes a trailing itemEndl, if present.
+func (t *Tree) expectEndl() {
+	if t.peek().typ == itemEndl {
+		t.next()
+	}
+}
+
+// parseInline consumes a run of itemText/itemInterpolation tokens, in
+// source order, into parent.
+func (t *Tree) parseInline(parent appender) {
+	for {
+		switch t.peek().typ {
+		case itemText:
+			parent.append(newText(t.next().val))
+		case itemInterpolation:
+			parent.append(newInterpolation(t.next().val))
+		default:
+			return
+		}
+	}
+}
+
+// parseTag parses a tag line: its name, classes, id, attributes, and
+// either inline text or a dot-block of literal text.
+func (t *Tree) parseTag(indent int) Node {
+	it := t.next() // itemTag
+	name := it.val
+	if name == "" {
+		name = "div"
+	}
+	tag := newTag(name)
+	for {
+		switch t.peek().typ {
+		case itemClass:
+			tag.append(newClass(t.next().val))
+		case itemId:
+			tag.append(newId(t.next().val))
+		case itemAttr:
+			tag.append(newAttr(t.next().val))
+		case itemBlockText:
+			t.next()
+			t.expectEndl()
+			tag.append(newBlockText(t.parseRawBlock(indent)))
+			return tag
+		case itemText, itemInterpolation:
+			t.parseInline(tag)
+			t.expectEndl()
+			return tag
+		case itemEndl, itemEOF:
+			t.expectEndl()
+			return tag
+		default:
+			bad := t.peek()
+			t.errorf(bad, "unexpected %s after tag", bad)
+			t.sync()
+			return tag
+		}
+	}
+}
+
+// parseRawBlock consumes every following line indented deeper than
+// parentIndent and returns its raw, unreinterpreted source text. It is used
+// for dot-blocks and filters, neither of which re-lex their body as Jade.
+func (t *Tree) parseRawBlock(parentIndent int) string {
+	startPos, endPos := -1, -1
+	for {
+		save := t.pos
+		first := t.peek()
+		lineIndent, ok := t.consumeIndent()
+		if !ok || lineIndent <= parentIndent {
+			t.pos = save
+			break
+		}
+		if startPos == -1 {
+			startPos = first.pos
+		}
+		for {
+			nt := t.next()
+			if nt.typ == itemEndl || nt.typ == itemEOF {
+				endPos = nt.pos + len(nt.val)
+				break
+			}
+		}
+	}
+	if startPos == -1 {
+		return ""
+	}
+	return t.text[startPos:endPos]
+}
+
+// splitNameArgs splits "name(args)" into its name and argument list; args
+// is empty if s has no parenthesized argument list.
+func splitNameArgs(s string) (name, args string) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '('); i >= 0 && strings.HasSuffix(s, ")") {
+		return s[:i], s[i+1 : len(s)-1]
+	}
+	return s, ""
+}
+
+// parseMixinDef parses "mixin name(args)". The indented lines that follow
+// become its body through Parse's indentation stack, not here.
+func (t *Tree) parseMixinDef() Node {
+	it := t.next()
+	t.expectEndl()
+	name, args := splitNameArgs(it.val)
+	return newMixinDef(name, args)
+}
+
+// parseMixinCall parses "+name(args)".
+func (t *Tree) parseMixinCall() Node {
+	it := t.next()
+	t.expectEndl()
+	name, args := splitNameArgs(it.val)
+	return newMixinCall(name, args)
+}
@@ -0,0 +1,67 @@
+// Copyright 2013 Benjamin Gentil
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jade
+
+import (
+	"bytes"
+	"testing"
+)
+
+func parseHTML(t *testing.T, input string) string {
+	t.Helper()
+	tree, err := New("name").Parse(input, "", "", make(map[string]*Tree), nil)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", input, err)
+	}
+	return tree.Root.HTMLString()
+}
+
+// TestParseChainedClassAndID guards against lexClass/lexId leaking the
+// delimiter they just consumed ('.', '#') into the following token's value.
+func TestParseChainedClassAndID(t *testing.T) {
+	cases := []struct {
+		input string
+		want  string
+	}{
+		{"div.foo.baz\n", `<div class="foo baz"></div>`},
+		{"div.foo#id1\n", `<div id="id1" class="foo"></div>`},
+	}
+	for _, c := range cases {
+		if got := parseHTML(t, c.input); got != c.want {
+			t.Errorf("parse(%q) = %q, want %q", c.input, got, c.want)
+		}
+	}
+}
+
+// TestParseAttrClassMerge guards against the same missing-ignore() bug
+// corrupting the tag with a stray leading '(' from the unconsumed class
+// delimiter when a dot-class is followed directly by an attribute list.
+func TestParseAttrClassMerge(t *testing.T) {
+	got := parseHTML(t, `div.foo(class="bar")`+"\n")
+	want := `<div class="foo bar"></div>`
+	if got != want {
+		t.Errorf("parse(div.foo(class=bar)) = %q, want %q", got, want)
+	}
+}
+
+// TestParseMixinCallExpands guards against mixin calls silently rendering
+// as nothing: Tree.Parse must splice the definition's body in for every
+// call to it.
+func TestParseMixinCallExpands(t *testing.T) {
+	input := "mixin greet\n  p Hello\n+greet\n"
+	tree, err := New("name").Parse(input, "", "", make(map[string]*Tree), nil)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var b bytes.Buffer
+	if err := tree.Root.Render(&b); err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	want := "<p>Hello</p>"
+	if b.String() != want {
+		t.Errorf("rendered %q, want %q", b.String(), want)
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2013 Benjamin Gentil
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package query lets callers find and mutate nodes in a parsed Jade tree
+// using CSS selectors, the way goquery does for parsed HTML.
+package query
+
+import (
+	"sort"
+
+	"gojade/jade"
+)
+
+// entry is a matched node together with enough information to mutate its
+// place in the tree: the container node holding it, and the index it held
+// in that container's children at match time.
+type entry struct {
+	node   jade.Node
+	parent jade.Node
+	index  int
+}
+
+// Selection is a set of nodes found in a Tree, plus the operations to
+// inspect or mutate them in place.
+type Selection struct {
+	items []entry
+}
+
+// FromTree returns the root Selection, containing the whole template.
+func FromTree(t *jade.Tree) *Selection {
+	return &Selection{items: []entry{{node: t.Root}}}
+}
+
+// Find returns a new Selection containing every node, anywhere under s,
+// that matches selector. selector covers tag names, #id, .class, [attr],
+// [attr=val], and the descendant (" ") and child (">") combinators.
+func (s *Selection) Find(sel string) *Selection {
+	compiled := compile(sel)
+	out := &Selection{}
+	seen := map[jade.Node]bool{}
+	for _, it := range s.items {
+		walk(it.node, nil, compiled, out, seen)
+	}
+	return out
+}
+
+// walk visits every descendant of n, recording matches of sel in out.
+// ancestors is the chain of *jade.TagNode ancestors from the root down to
+// n, used to satisfy descendant/child combinators.
+func walk(n jade.Node, ancestors []*jade.TagNode, sel selector, out *Selection, seen map[jade.Node]bool) {
+	kids := children(n)
+	for i, c := range kids {
+		next := ancestors
+		if tag, ok := c.(*jade.TagNode); ok {
+			if !seen[c] && matchChain(tag, ancestors, sel) {
+				seen[c] = true
+				out.items = append(out.items, entry{node: c, parent: n, index: i})
+			}
+			next = append(append([]*jade.TagNode{}, ancestors...), tag)
+		}
+		walk(c, next, sel, out, seen)
+	}
+}
+
+// Each calls f once for every node in the selection, in order.
+func (s *Selection) Each(f func(int, jade.Node)) *Selection {
+	for i, it := range s.items {
+		f(i, it.node)
+	}
+	return s
+}
+
+// Len returns the number of nodes in the selection.
+func (s *Selection) Len() int {
+	return len(s.items)
+}
+
+// Nodes returns the selection's matched nodes in order.
+func (s *Selection) Nodes() []jade.Node {
+	nodes := make([]jade.Node, len(s.items))
+	for i, it := range s.items {
+		nodes[i] = it.node
+	}
+	return nodes
+}
+
+// AddClass adds class to every tag in the selection, unless it is already
+// present.
+func (s *Selection) AddClass(class string) *Selection {
+	for _, it := range s.items {
+		tag, ok := it.node.(*jade.TagNode)
+		if !ok || hasClass(tag, class) {
+			continue
+		}
+		tag.Nodes = append(tag.Nodes, &jade.ClassNode{NodeType: jade.NodeClass, Class: []byte(class)})
+	}
+	return s
+}
+
+// SetAttr sets name to value on every tag in the selection, replacing any
+// existing attribute of that name.
+func (s *Selection) SetAttr(name, value string) *Selection {
+	for _, it := range s.items {
+		tag, ok := it.node.(*jade.TagNode)
+		if !ok {
+			continue
+		}
+		found := false
+		for i, c := range tag.Nodes {
+			if an, ok := c.(*jade.AttrNode); ok && an.Name == name {
+				tag.Nodes[i] = &jade.AttrNode{NodeType: jade.NodeAttr, Name: name, Value: value, Quote: '"'}
+				found = true
+				break
+			}
+		}
+		if !found {
+			tag.Nodes = append(tag.Nodes, &jade.AttrNode{NodeType: jade.NodeAttr, Name: name, Value: value, Quote: '"'})
+		}
+	}
+	return s
+}
+
+// Append adds n as the last child of every node in the selection.
+func (s *Selection) Append(n jade.Node) *Selection {
+	for _, it := range s.items {
+		setChildren(it.node, append(children(it.node), n))
+	}
+	return s
+}
+
+// Before inserts n as the immediately preceding sibling of every node in
+// the selection.
+func (s *Selection) Before(n jade.Node) *Selection {
+	byParent := map[jade.Node][]int{}
+	for _, it := range s.items {
+		byParent[it.parent] = append(byParent[it.parent], it.index)
+	}
+	for parent, indexes := range byParent {
+		kids := children(parent)
+		if kids == nil {
+			continue
+		}
+		// Insert from the highest index down: each insertion shifts only
+		// the elements after it, so earlier (lower) indexes in the same
+		// parent stay valid for the remaining insertions.
+		sort.Sort(sort.Reverse(sort.IntSlice(indexes)))
+		for _, idx := range indexes {
+			if idx < 0 || idx > len(kids) {
+				continue
+			}
+			kids = append(kids, nil)
+			copy(kids[idx+1:], kids[idx:])
+			kids[idx] = n
+		}
+		setChildren(parent, kids)
+	}
+	return s
+}
+
+// Remove deletes every node in the selection from its parent.
+func (s *Selection) Remove() *Selection {
+	for _, it := range s.items {
+		kids := children(it.parent)
+		for i, c := range kids {
+			if c == it.node {
+				setChildren(it.parent, append(kids[:i], kids[i+1:]...))
+				break
+			}
+		}
+	}
+	return s
+}
+
+// children returns the child slice of any container Node, or nil if n
+// cannot hold children.
+func children(n jade.Node) []jade.Node {
+	switch t := n.(type) {
+	case *jade.TagNode:
+		return t.Nodes
+	case *jade.ListNode:
+		return t.Nodes
+	case *jade.BlockNode:
+		return t.Nodes
+	case *jade.MixinDefNode:
+		return t.Nodes
+	}
+	return nil
+}
+
+// setChildren replaces the child slice of any container Node.
+func setChildren(n jade.Node, nodes []jade.Node) {
+	switch t := n.(type) {
+	case *jade.TagNode:
+		t.Nodes = nodes
+	case *jade.ListNode:
+		t.Nodes = nodes
+	case *jade.BlockNode:
+		t.Nodes = nodes
+	case *jade.MixinDefNode:
+		t.Nodes = nodes
+	}
+}
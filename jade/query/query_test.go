@@ -0,0 +1,37 @@
+// Copyright 2013 Benjamin Gentil
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package query
+
+import (
+	"bytes"
+	"testing"
+
+	"gojade/jade"
+)
+
+// TestSelectionBeforeEachSibling guards against Before() using a stale
+// index captured at Find() time: inserting a marker before each sibling
+// under the same parent must place one marker immediately before each
+// match, not cluster every marker at the front of the parent.
+func TestSelectionBeforeEachSibling(t *testing.T) {
+	tree, err := jade.New("name").Parse("ul\n  li.a\n  li.b\n  li.c\n", "", "", make(map[string]*jade.Tree), nil)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	marker := &jade.TextNode{Text: []byte("MARK")}
+	FromTree(tree).Find("li").Before(marker)
+
+	var b bytes.Buffer
+	if err := tree.Root.Render(&b); err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+
+	want := `<ul>MARK<li class="a"></li>MARK<li class="b"></li>MARK<li class="c"></li></ul>`
+	if b.String() != want {
+		t.Errorf("rendered %q, want %q", b.String(), want)
+	}
+}
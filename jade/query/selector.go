@@ -0,0 +1,214 @@
+// Copyright 2013 Benjamin Gentil
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// CSS selector compiler and matcher.
+
+package query
+
+import (
+	"strings"
+
+	"gojade/jade"
+)
+
+// attrTest matches a single [attr] or [attr=val] selector component.
+type attrTest struct {
+	name   string
+	val    string
+	hasVal bool
+}
+
+// simpleSelector matches a single compound selector, e.g. "div.foo#bar[x=y]".
+type simpleSelector struct {
+	tag     string // "" or "*" matches any tag
+	id      string
+	classes []string
+	attrs   []attrTest
+}
+
+// combKind identifies how a step relates to the step before it.
+type combKind int
+
+const (
+	combDescendant combKind = iota // "a b": b anywhere under a
+	combChild                      // "a > b": b a direct child of a
+)
+
+// step is one compound selector plus the combinator linking it to the
+// previous step in the chain.
+type step struct {
+	sel  simpleSelector
+	comb combKind
+}
+
+// selector is a compiled chain of steps, e.g. "form .error > span".
+type selector []step
+
+// compile parses a CSS selector covering tag names, #id, .class, [attr],
+// [attr=val], and the descendant and child combinators.
+func compile(s string) selector {
+	fields := strings.Fields(strings.ReplaceAll(s, ">", " > "))
+	sel := make(selector, 0, len(fields))
+	comb := combDescendant
+	for _, f := range fields {
+		if f == ">" {
+			comb = combChild
+			continue
+		}
+		sel = append(sel, step{sel: parseSimple(f), comb: comb})
+		comb = combDescendant
+	}
+	return sel
+}
+
+// parseSimple parses a single compound selector such as "div.foo#bar[x=y]".
+func parseSimple(s string) simpleSelector {
+	var ss simpleSelector
+	i := 0
+	for i < len(s) && s[i] != '.' && s[i] != '#' && s[i] != '[' {
+		i++
+	}
+	ss.tag = s[:i]
+	for i < len(s) {
+		switch s[i] {
+		case '.':
+			j := i + 1
+			for j < len(s) && s[j] != '.' && s[j] != '#' && s[j] != '[' {
+				j++
+			}
+			ss.classes = append(ss.classes, s[i+1:j])
+			i = j
+		case '#':
+			j := i + 1
+			for j < len(s) && s[j] != '.' && s[j] != '#' && s[j] != '[' {
+				j++
+			}
+			ss.id = s[i+1 : j]
+			i = j
+		case '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				i = len(s)
+				break
+			}
+			body := s[i+1 : i+end]
+			if k := strings.IndexByte(body, '='); k >= 0 {
+				ss.attrs = append(ss.attrs, attrTest{
+					name:   strings.TrimSpace(body[:k]),
+					val:    unquote(strings.TrimSpace(body[k+1:])),
+					hasVal: true,
+				})
+			} else {
+				ss.attrs = append(ss.attrs, attrTest{name: strings.TrimSpace(body)})
+			}
+			i += end + 1
+		default:
+			i++
+		}
+	}
+	return ss
+}
+
+// matchChain reports whether node satisfies the full selector, given its
+// chain of ancestors ordered from the root down to its immediate parent.
+func matchChain(node *jade.TagNode, ancestors []*jade.TagNode, sel selector) bool {
+	if len(sel) == 0 {
+		return false
+	}
+	last := sel[len(sel)-1]
+	if !matchSimple(node, last.sel) {
+		return false
+	}
+	return matchAncestors(ancestors, sel[:len(sel)-1], last.comb)
+}
+
+// matchAncestors reports whether ancestors (root-to-parent order) satisfies
+// the remaining selector steps. comb is the combinator linking the step
+// already matched to the last step of sel.
+func matchAncestors(ancestors []*jade.TagNode, sel selector, comb combKind) bool {
+	if len(sel) == 0 {
+		return true
+	}
+	last := sel[len(sel)-1]
+	rest := sel[:len(sel)-1]
+	if comb == combChild {
+		if len(ancestors) == 0 {
+			return false
+		}
+		parent := ancestors[len(ancestors)-1]
+		if !matchSimple(parent, last.sel) {
+			return false
+		}
+		return matchAncestors(ancestors[:len(ancestors)-1], rest, last.comb)
+	}
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		if matchSimple(ancestors[i], last.sel) && matchAncestors(ancestors[:i], rest, last.comb) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSimple(tag *jade.TagNode, sel simpleSelector) bool {
+	if sel.tag != "" && sel.tag != "*" && string(tag.Tag) != sel.tag {
+		return false
+	}
+	if sel.id != "" && !hasID(tag, sel.id) {
+		return false
+	}
+	for _, want := range sel.classes {
+		if !hasClass(tag, want) {
+			return false
+		}
+	}
+	for _, want := range sel.attrs {
+		if !hasAttr(tag, want) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasID(tag *jade.TagNode, id string) bool {
+	for _, c := range tag.Nodes {
+		if idn, ok := c.(*jade.IdNode); ok && idn.String() == id {
+			return true
+		}
+	}
+	return false
+}
+
+func hasClass(tag *jade.TagNode, class string) bool {
+	for _, c := range tag.Nodes {
+		if cn, ok := c.(*jade.ClassNode); ok && cn.String() == class {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAttr(tag *jade.TagNode, want attrTest) bool {
+	for _, c := range tag.Nodes {
+		an, ok := c.(*jade.AttrNode)
+		if !ok || an.Name != want.name {
+			continue
+		}
+		if !want.hasVal {
+			return true
+		}
+		return an.Value == want.val
+	}
+	return false
+}
+
+// unquote strips a matching pair of surrounding single or double quotes.
+func unquote(s string) string {
+	if len(s) >= 2 {
+		if (s[0] == '"' && s[len(s)-1] == '"') || (s[0] == '\'' && s[len(s)-1] == '\'') {
+			return s[1 : len(s)-1]
+		}
+	}
+	return s
+}
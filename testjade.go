@@ -9,6 +9,7 @@ import (
 	"flag"
 	"fmt"
 	"gojade/jade"
+	"os"
 )
 
 var debug = flag.Bool("debug", false, "Enable debug output")
@@ -78,5 +79,7 @@ func main() {
 		displayNode(tmpl.Root, 0)
 	}
 
-	print(tmpl.Root.HTMLString())
+	if err := tmpl.RenderMinified(os.Stdout); err != nil {
+		fmt.Printf("Unexpected error: %v", err)
+	}
 }